@@ -0,0 +1,799 @@
+// Package apollo provides a go-zero configcenter Subscriber backed by
+// Apollo (https://github.com/apolloconfig/agollo), so a typed
+// configcenter.ConfigCenter[T] can be bound to config stored in Apollo
+// namespaces instead of a local file or etcd.
+package apollo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apolloconfig/agollo/v4"
+	"github.com/apolloconfig/agollo/v4/agcache"
+	"github.com/apolloconfig/agollo/v4/env/config"
+	"github.com/apolloconfig/agollo/v4/extension"
+	"github.com/apolloconfig/agollo/v4/storage"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// defaultEncryptedKeyPattern matches keys whose value is expected to be an
+// encrypted ciphertext, when ApolloConf.Decryptor is set but
+// EncryptedKeyPattern is left empty.
+const defaultEncryptedKeyPattern = `^ENC\(.*\)$`
+
+// ApolloConf is the configuration used to build an Apollo backed Subscriber.
+type ApolloConf struct {
+	AppID          string
+	Cluster        string
+	NamespaceName  string
+	// Namespaces, when set, lists every namespace the subscriber should
+	// watch in addition to NamespaceName. Namespaces joined later at
+	// runtime via SyncAndUpdate are appended here too, so the field
+	// always reflects the full watch list.
+	Namespaces     []string
+	IP             string
+	MetaAddr       string
+	Secret         string
+	IsBackupConfig bool
+	BackupPath     string
+	// MustStart, when true, fails subscriber construction if Apollo could
+	// not be reached and no namespace was synced from the server.
+	MustStart bool
+	// Format controls how Value() renders the namespace content:
+	// "properties" renders key=value lines; any other value, including
+	// "json" (the default) and "yaml", renders JSON. It is also inferred
+	// from the NamespaceName suffix when left empty.
+	Format string
+	// Key, when set, narrows Value() to a single key inside the
+	// namespace instead of the whole document.
+	Key string
+	// Parser overrides the builtin Parser picked by namespace suffix for
+	// namespaces agollo stores as a single blob under the "content" key
+	// (yaml, xml, txt, and non-properties namespaces in general).
+	Parser Parser
+	// CacheFactory, when set, replaces agollo's default in-memory cache
+	// (e.g. with a bigcache/freecache/Redis-backed implementation), which
+	// matters when a single process watches hundreds of namespaces.
+	CacheFactory agcache.CacheFactory
+	// Decryptor, when set, is invoked for every cached value whose key
+	// matches EncryptedKeyPattern, so secrets stored encrypted in Apollo
+	// never reach the rendered config in ciphertext form.
+	Decryptor func(namespace, key string, ciphertext []byte) ([]byte, error)
+	// EncryptedKeyPattern selects which keys are passed through
+	// Decryptor. Defaults to `^ENC\(.*\)$` when Decryptor is set.
+	EncryptedKeyPattern string
+	// KeyPrefix, when set, narrows Value() to the subtree of
+	// NamespaceName whose keys start with it followed by a '.': the prefix
+	// and dot are stripped and dotted segments of what remains become
+	// nested objects, e.g. "myservice.http.port" under prefix
+	// "myservice.http" becomes {"port": ...}. Keys such as
+	// "myservice.http2.foo", and a key equal to the prefix itself, are
+	// left out. Listeners only fire for changes under this prefix.
+	KeyPrefix string
+}
+
+// Validate reports whether conf has enough information to talk to Apollo.
+func (c ApolloConf) Validate() error {
+	if c.AppID == "" {
+		return errors.New("apollo: AppID is required")
+	}
+	if c.MetaAddr == "" {
+		return errors.New("apollo: MetaAddr is required")
+	}
+
+	return nil
+}
+
+// apolloSubscriber implements configcenter.Subscriber on top of an Apollo
+// client.
+type apolloSubscriber struct {
+	client agollo.Client
+	conf   ApolloConf
+
+	lock            sync.RWMutex
+	value           string
+	namespaces      []string
+	listeners       []func()
+	changeListeners []func(ConfigChange)
+	ready           chan struct{}
+	readyOnce       sync.Once
+	encryptedKey    *regexp.Regexp
+	// appConfig is the *config.AppConfig instance agollo was started with.
+	// SyncAndUpdate mutates its NamespaceName in place so a namespace
+	// joined after startup stays registered with agollo's own long-poll
+	// machinery, not just this subscriber's bookkeeping.
+	appConfig *config.AppConfig
+}
+
+// ChangeEntry describes how a single key's value changed.
+type ChangeEntry struct {
+	OldValue string
+	NewValue string
+}
+
+// ConfigChange is the structured diff delivered to listeners registered
+// via AddChangeListener, built from agollo's own ChangeEvent for the
+// namespace that changed.
+type ConfigChange struct {
+	Namespace string
+	Added     map[string]ChangeEntry
+	Modified  map[string]ChangeEntry
+	Deleted   map[string]ChangeEntry
+}
+
+// touchesPrefix reports whether any key in the diff is in the prefix
+// subtree, i.e. equals prefix or starts with prefix followed by a '.'
+// segment boundary - "myservice.http" must not match "myservice.http2".
+func (c ConfigChange) touchesPrefix(prefix string) bool {
+	for _, keys := range []map[string]ChangeEntry{c.Added, c.Modified, c.Deleted} {
+		for key := range keys {
+			if _, ok := prefixMatch(key, prefix); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewApolloSubscriber creates a Subscriber backed by Apollo using conf. If
+// conf.MustStart is true and no namespace could be synced from the Apollo
+// server, it returns an error instead of starting with an empty config.
+func NewApolloSubscriber(conf ApolloConf) (*apolloSubscriber, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	var encryptedKey *regexp.Regexp
+	if conf.Decryptor != nil {
+		pattern := conf.EncryptedKeyPattern
+		if pattern == "" {
+			pattern = defaultEncryptedKeyPattern
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("apollo: invalid EncryptedKeyPattern: %w", err)
+		}
+		encryptedKey = re
+	}
+
+	if conf.CacheFactory != nil {
+		extension.SetCacheFactory(conf.CacheFactory)
+	}
+
+	appConfig := buildApolloConfig(conf)
+
+	client, err := agollo.StartWithConfig(func() (*config.AppConfig, error) {
+		return appConfig, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("apollo: start failed: %w", err)
+	}
+
+	sub := &apolloSubscriber{
+		client:       client,
+		conf:         conf,
+		namespaces:   namespaceList(conf),
+		ready:        make(chan struct{}),
+		encryptedKey: encryptedKey,
+		appConfig:    appConfig,
+	}
+
+	if !sub.syncedAnyNamespace() {
+		if conf.MustStart {
+			return nil, errors.New("apollo: start failed cause no config was read")
+		}
+
+		if err := sub.loadFromBackup(); err != nil {
+			return nil, err
+		}
+	} else if err := sub.loadValue(); err != nil {
+		return nil, err
+	}
+
+	sub.markReady()
+	client.AddChangeListener(sub)
+
+	return sub, nil
+}
+
+// Ready returns a channel that closes once the subscriber's first
+// successful load - remote or backup - has completed, so callers can
+// block service startup until config is actually usable.
+func (s *apolloSubscriber) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *apolloSubscriber) markReady() {
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+}
+
+// syncedAnyNamespace reports whether at least one watched namespace has a
+// non-empty cache, i.e. was actually synced from the Apollo server.
+func (s *apolloSubscriber) syncedAnyNamespace() bool {
+	for _, ns := range s.namespaces {
+		if s.client.GetConfigCache(ns).EntryCount() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFromBackup loads every watched namespace from its on-disk backup
+// file (BackupPath/<AppID>/<Namespace>.json) so the service can still boot
+// while Apollo is unreachable and MustStart is false. It applies the same
+// Key/KeyPrefix narrowing as loadValue, so a subscriber bound to a single
+// key or subtree still gets the shape it expects during an outage instead
+// of the full merged document.
+func (s *apolloSubscriber) loadFromBackup() error {
+	doc := make(map[string]interface{})
+	// primary is NamespaceName's own backup, the document loadValue
+	// narrows by Key/KeyPrefix.
+	var primary map[string]interface{}
+	for _, ns := range s.namespaces {
+		path := filepath.Join(s.conf.BackupPath, s.conf.AppID, ns+".json")
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("apollo: load backup for namespace %s: %w", ns, err)
+		}
+
+		nsDoc := make(map[string]interface{})
+		if err := json.Unmarshal(raw, &nsDoc); err != nil {
+			return fmt.Errorf("apollo: parse backup for namespace %s: %w", ns, err)
+		}
+		if ns == s.conf.NamespaceName {
+			primary = nsDoc
+		}
+
+		if len(s.namespaces) == 1 {
+			doc = nsDoc
+		} else {
+			doc[ns] = nsDoc
+		}
+	}
+
+	if s.conf.Key != "" {
+		raw, ok := primary[s.conf.Key]
+		if !ok {
+			return fmt.Errorf("apollo: key not found in backup: %s", s.conf.Key)
+		}
+
+		s.lock.Lock()
+		s.value = toString(raw)
+		s.lock.Unlock()
+		return nil
+	}
+
+	if s.conf.KeyPrefix != "" {
+		flat := make(map[string]string)
+		for key, value := range primary {
+			rest, ok := prefixMatch(key, s.conf.KeyPrefix)
+			if !ok || rest == "" {
+				continue
+			}
+			flat[rest] = toString(value)
+		}
+		doc = nestDottedKeys(flat)
+	}
+
+	rendered, err := renderDoc(doc, s.conf.Format, s.conf.NamespaceName)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.value = rendered
+	s.lock.Unlock()
+	return nil
+}
+
+// MustNewApolloSubscriber is like NewApolloSubscriber but panics on error.
+func MustNewApolloSubscriber(conf ApolloConf) *apolloSubscriber {
+	sub, err := NewApolloSubscriber(conf)
+	logx.Must(err)
+	return sub
+}
+
+// AddListener registers fn to be called whenever the watched namespace(s)
+// change, for callers that don't need the structured diff. These listeners
+// are kept apart from AddChangeListener's and are called by
+// handleConfigChange, so both OnChange and OnNewestChange notify them.
+func (s *apolloSubscriber) AddListener(fn func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// AddChangeListener registers fn to be called with a structured diff of
+// exactly what changed whenever the watched namespace(s) change, e.g. so a
+// caller can rebuild a DB pool only when database.* keys change instead of
+// re-diffing the whole config on every update.
+func (s *apolloSubscriber) AddChangeListener(fn func(ConfigChange)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.changeListeners = append(s.changeListeners, fn)
+}
+
+// Value returns the current rendering of the watched namespace(s).
+func (s *apolloSubscriber) Value() (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.value, nil
+}
+
+// SyncAndUpdate syncs namespace from Apollo on first access, joining it
+// to the set of namespaces this subscriber watches for good: it mirrors
+// agollo v4.4.0's own SyncAndUpdate by registering the namespace with
+// agollo's live notification/long-poll machinery (via GetConfigAndInit,
+// the same entry point agollo uses to sync every namespace it watches)
+// and by appending it to the AppConfig agollo was started with, so later
+// poll cycles keep treating it as watched. Namespaces already present in
+// conf.Namespaces/NamespaceName are a no-op.
+func (s *apolloSubscriber) SyncAndUpdate(namespace string) error {
+	s.lock.Lock()
+	for _, ns := range s.namespaces {
+		if ns == namespace {
+			s.lock.Unlock()
+			return nil
+		}
+	}
+	s.lock.Unlock()
+
+	// Force agollo to sync the namespace from the remote server, populate
+	// its local cache, and register it for long-poll notifications.
+	s.client.GetConfigAndInit(namespace)
+
+	s.lock.Lock()
+	s.namespaces = append(s.namespaces, namespace)
+	s.conf.Namespaces = append(s.conf.Namespaces, namespace)
+	if s.appConfig != nil {
+		if s.appConfig.NamespaceName == "" {
+			s.appConfig.NamespaceName = namespace
+		} else {
+			s.appConfig.NamespaceName += "," + namespace
+		}
+	}
+	s.lock.Unlock()
+
+	return s.loadValue()
+}
+
+// handleConfigChange reloads Value() from the client's caches and notifies
+// every registered listener. It is safe to call concurrently.
+func (s *apolloSubscriber) handleConfigChange() {
+	if err := s.loadValue(); err != nil {
+		logx.Errorf("apollo: reload config failed: %v", err)
+		return
+	}
+
+	s.lock.RLock()
+	listeners := make([]func(), len(s.listeners))
+	copy(listeners, s.listeners)
+	s.lock.RUnlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// OnChange implements storage.ChangeListener. It reloads Value(), notifies
+// plain listeners via handleConfigChange, then delivers a structured
+// ConfigChange diff to every listener registered via AddChangeListener.
+func (s *apolloSubscriber) OnChange(event *storage.ChangeEvent) {
+	change := ConfigChange{
+		Namespace: event.Namespace,
+		Added:     make(map[string]ChangeEntry),
+		Modified:  make(map[string]ChangeEntry),
+		Deleted:   make(map[string]ChangeEntry),
+	}
+
+	for key, c := range event.Changes {
+		entry := ChangeEntry{OldValue: toString(c.OldValue), NewValue: toString(c.NewValue)}
+		switch c.ChangeType {
+		case storage.ADDED:
+			change.Added[key] = entry
+		case storage.MODIFIED:
+			change.Modified[key] = entry
+		case storage.DELETED:
+			change.Deleted[key] = entry
+		}
+	}
+
+	if s.conf.KeyPrefix != "" && !change.touchesPrefix(s.conf.KeyPrefix) {
+		return
+	}
+
+	s.handleConfigChange()
+
+	s.lock.RLock()
+	changeListeners := make([]func(ConfigChange), len(s.changeListeners))
+	copy(changeListeners, s.changeListeners)
+	s.lock.RUnlock()
+
+	for _, fn := range changeListeners {
+		fn(change)
+	}
+}
+
+// OnNewestChange implements storage.ChangeListener. Like OnChange, it
+// skips the reload when KeyPrefix is set and none of the changed keys
+// fall under it, so plain listeners don't fire for unrelated namespace
+// changes just because agollo drove this callback instead of OnChange.
+func (s *apolloSubscriber) OnNewestChange(event *storage.FullChangeEvent) {
+	if s.conf.KeyPrefix != "" {
+		inScope := false
+		for key := range event.Changes {
+			if _, ok := prefixMatch(key, s.conf.KeyPrefix); ok {
+				inScope = true
+				break
+			}
+		}
+		if !inScope {
+			return
+		}
+	}
+
+	s.handleConfigChange()
+}
+
+// loadValue rebuilds s.value from the current state of every watched
+// namespace's cache.
+func (s *apolloSubscriber) loadValue() error {
+	s.lock.RLock()
+	conf := s.conf
+	namespaces := append([]string(nil), s.namespaces...)
+	s.lock.RUnlock()
+
+	if len(namespaces) == 0 {
+		namespaces = []string{conf.NamespaceName}
+	}
+
+	if conf.Key != "" {
+		cache := s.client.GetConfigCache(conf.NamespaceName)
+		raw, err := cache.Get(conf.Key)
+		if err != nil {
+			return fmt.Errorf("apollo: key not found: %s", conf.Key)
+		}
+
+		s.lock.Lock()
+		s.value = toString(raw)
+		s.lock.Unlock()
+		return nil
+	}
+
+	if conf.KeyPrefix != "" {
+		doc, err := s.keyPrefixDoc(conf.KeyPrefix)
+		if err != nil {
+			return err
+		}
+
+		rendered, err := renderDoc(doc, conf.Format, conf.NamespaceName)
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.value = rendered
+		s.lock.Unlock()
+		return nil
+	}
+
+	// Single namespace: flatten its keys directly into the document so
+	// existing single-namespace consumers see a flat object, not one
+	// nested under the namespace name.
+	if len(namespaces) == 1 {
+		doc, err := s.namespaceDoc(namespaces[0])
+		if err != nil {
+			return err
+		}
+
+		rendered, err := renderDoc(doc, conf.Format, namespaces[0])
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.value = rendered
+		s.lock.Unlock()
+		return nil
+	}
+
+	// Multiple namespaces: merge into a document keyed by namespace.
+	merged := make(map[string]interface{}, len(namespaces))
+	for _, ns := range namespaces {
+		doc, err := s.namespaceDoc(ns)
+		if err != nil {
+			return err
+		}
+		merged[ns] = doc
+	}
+
+	rendered, err := renderDoc(merged, conf.Format, conf.NamespaceName)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.value = rendered
+	s.lock.Unlock()
+	return nil
+}
+
+// prefixMatch reports whether key is in the prefix subtree: either an
+// exact match or prefix followed by a '.' segment boundary. When ok is
+// true, rest is key with the prefix and separating dot stripped.
+func prefixMatch(key, prefix string) (rest string, ok bool) {
+	if key == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(key, prefix+".") {
+		return key[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// keyPrefixDoc projects the subtree of NamespaceName under prefix (as
+// matched by prefixMatch) into a nested map: the prefix and its dot are
+// stripped from each key and its remaining dotted segments become nested
+// objects. A key equal to prefix itself is left out.
+func (s *apolloSubscriber) keyPrefixDoc(prefix string) (map[string]interface{}, error) {
+	cache := s.client.GetConfigCache(s.conf.NamespaceName)
+
+	flat := make(map[string]string)
+	cache.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		rest, inScope := prefixMatch(k, prefix)
+		if !inScope || rest == "" {
+			return true
+		}
+
+		flat[rest] = toString(value)
+		return true
+	})
+
+	return nestDottedKeys(flat), nil
+}
+
+// nestDottedKeys turns a flat map of dotted keys into nested objects, e.g.
+// {"http.port": "8080"} becomes {"http": {"port": "8080"}}.
+func nestDottedKeys(flat map[string]string) map[string]interface{} {
+	nested := make(map[string]interface{})
+	for key, value := range flat {
+		segments := strings.Split(key, ".")
+		cur := nested
+		for i, seg := range segments {
+			if i == len(segments)-1 {
+				cur[seg] = value
+				continue
+			}
+
+			next, ok := cur[seg].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				cur[seg] = next
+			}
+			cur = next
+		}
+	}
+	return nested
+}
+
+// namespaceDoc collects the content currently cached for namespace into a
+// plain map. Namespaces agollo stores as a single blob under the special
+// "content" key (yaml/xml/txt, and json namespaces synced from a real
+// Apollo server) are parsed with the Parser selected for that namespace;
+// everything else is read back key by key.
+func (s *apolloSubscriber) namespaceDoc(namespace string) (map[string]interface{}, error) {
+	cache := s.client.GetConfigCache(namespace)
+
+	if raw, err := cache.Get(contentKey); err == nil {
+		if content, ok := raw.(string); ok {
+			parser := parserFor(namespace, s.conf.Format, s.conf.Parser)
+			doc, err := parser.Parse(namespace, []byte(content))
+			if err != nil {
+				return nil, err
+			}
+			return s.decryptDoc(namespace, doc)
+		}
+	}
+
+	doc := make(map[string]interface{})
+	cache.Range(func(key, value interface{}) bool {
+		if k, ok := key.(string); ok {
+			doc[k] = value
+		}
+		return true
+	})
+	return s.decryptDoc(namespace, doc)
+}
+
+// decryptDoc runs conf.Decryptor over every value in doc whose key matches
+// the subscriber's encrypted-key pattern, replacing it with the plaintext.
+func (s *apolloSubscriber) decryptDoc(namespace string, doc map[string]interface{}) (map[string]interface{}, error) {
+	if s.conf.Decryptor == nil {
+		return doc, nil
+	}
+
+	pattern, err := s.encryptedKeyPattern()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range doc {
+		if !pattern.MatchString(key) {
+			continue
+		}
+
+		plain, err := s.conf.Decryptor(namespace, key, []byte(toString(value)))
+		if err != nil {
+			return nil, fmt.Errorf("apollo: decrypt %s/%s: %w", namespace, key, err)
+		}
+		doc[key] = string(plain)
+	}
+
+	return doc, nil
+}
+
+// encryptedKeyPattern returns the compiled EncryptedKeyPattern regexp,
+// compiling and caching it lazily if the subscriber wasn't built through
+// NewApolloSubscriber. Every test in this package, and any other
+// same-package caller, builds apolloSubscriber as a direct struct literal,
+// which otherwise leaves encryptedKey nil and panics on the first decrypt.
+func (s *apolloSubscriber) encryptedKeyPattern() (*regexp.Regexp, error) {
+	s.lock.RLock()
+	re := s.encryptedKey
+	s.lock.RUnlock()
+	if re != nil {
+		return re, nil
+	}
+
+	pattern := s.conf.EncryptedKeyPattern
+	if pattern == "" {
+		pattern = defaultEncryptedKeyPattern
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("apollo: invalid EncryptedKeyPattern: %w", err)
+	}
+
+	s.lock.Lock()
+	if s.encryptedKey == nil {
+		s.encryptedKey = compiled
+	}
+	re = s.encryptedKey
+	s.lock.Unlock()
+
+	return re, nil
+}
+
+// renderDoc serializes doc according to format. json is the default;
+// properties renders "key=value" lines sorted by key.
+func renderDoc(doc map[string]interface{}, format, namespace string) (string, error) {
+	if format == "" {
+		format = formatFromNamespace(namespace)
+	}
+
+	switch format {
+	case "properties":
+		return renderProperties(doc), nil
+	default:
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("apollo: marshal config failed: %w", err)
+		}
+		return string(b), nil
+	}
+}
+
+func renderProperties(doc map[string]interface{}) string {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(toString(doc[k]))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func formatFromNamespace(namespace string) string {
+	switch {
+	case strings.HasSuffix(namespace, ".properties"):
+		return "properties"
+	case strings.HasSuffix(namespace, ".yaml"), strings.HasSuffix(namespace, ".yml"):
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// namespaceList returns the full set of namespaces conf should watch at
+// startup: NamespaceName plus any extra Namespaces, de-duplicated.
+func namespaceList(conf ApolloConf) []string {
+	seen := make(map[string]bool, len(conf.Namespaces)+1)
+	var namespaces []string
+
+	add := func(ns string) {
+		if ns == "" || seen[ns] {
+			return
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+
+	add(conf.NamespaceName)
+	for _, ns := range conf.Namespaces {
+		add(ns)
+	}
+
+	return namespaces
+}
+
+// buildApolloConfig translates ApolloConf into agollo's own AppConfig. The
+// resulting NamespaceName is the comma-separated join of NamespaceName and
+// every entry in Namespaces, so agollo syncs and long-poll-watches all of
+// them from startup, not just the first.
+func buildApolloConfig(conf ApolloConf) *config.AppConfig {
+	ip := conf.IP
+	if ip == "" {
+		ip = conf.MetaAddr
+	}
+
+	return &config.AppConfig{
+		AppID:            conf.AppID,
+		Cluster:          conf.Cluster,
+		NamespaceName:    strings.Join(namespaceList(conf), ","),
+		IP:               ip,
+		Secret:           conf.Secret,
+		IsBackupConfig:   conf.IsBackupConfig,
+		BackupConfigPath: conf.BackupPath,
+		MustStart:        conf.MustStart,
+	}
+}
+
+// toString renders an arbitrary Apollo cache value as a string, the same
+// way agollo itself stringifies values for GetStringValue.
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+
+		// Marshal renders bare scalars (numbers, bools) without quotes
+		// already; only maps/slices need the JSON form, everything else
+		// should come back exactly like fmt.Sprintf would render it.
+		switch val.(type) {
+		case map[string]string, map[string]interface{}, []string, []interface{}:
+			return string(b)
+		default:
+			return fmt.Sprintf("%v", val)
+		}
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"container/list"
 	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/apolloconfig/agollo/v4/agcache"
+	"github.com/apolloconfig/agollo/v4/env/config"
 	"github.com/apolloconfig/agollo/v4/storage"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,17 +19,50 @@ import (
 // mockApolloClient mocks the Apollo client for testing
 type mockApolloClient struct {
 	configCache *mockCache
-	listeners   *list.List
+	// namespaceCaches, when a namespace has an entry, is returned by
+	// GetConfigCache instead of configCache - lets tests simulate more
+	// than one watched namespace, each with its own content.
+	namespaceCaches map[string]*mockCache
+	// syncedNamespaces records every namespace passed to GetConfigAndInit,
+	// in call order, so tests can assert SyncAndUpdate actually triggered
+	// a sync instead of only updating local bookkeeping.
+	syncedNamespaces []string
+	listeners        *list.List
 }
 
 func newMockApolloClient() *mockApolloClient {
 	return &mockApolloClient{
-		configCache: newMockCache(),
-		listeners:   list.New(),
+		configCache:     newMockCache(),
+		namespaceCaches: make(map[string]*mockCache),
+		listeners:       list.New(),
 	}
 }
 
+// newChangeEvent builds a storage.ChangeEvent for tests. Namespace is
+// promoted from storage.ChangeEvent's unexported embedded base type, so it
+// can't be set in a composite literal from outside the storage package -
+// it has to be assigned afterward instead.
+func newChangeEvent(namespace string, changes map[string]*storage.ConfigChange) *storage.ChangeEvent {
+	event := &storage.ChangeEvent{Changes: changes}
+	event.Namespace = namespace
+	return event
+}
+
+// withNamespaceCache returns the mockCache GetConfigCache will serve for
+// namespace, creating it on first use.
+func (m *mockApolloClient) withNamespaceCache(namespace string) *mockCache {
+	if c, ok := m.namespaceCaches[namespace]; ok {
+		return c
+	}
+	c := newMockCache()
+	m.namespaceCaches[namespace] = c
+	return c
+}
+
 func (m *mockApolloClient) GetConfigCache(namespace string) agcache.CacheInterface {
+	if c, ok := m.namespaceCaches[namespace]; ok {
+		return c
+	}
 	return m.configCache
 }
 
@@ -74,6 +110,7 @@ func (m *mockApolloClient) GetConfig(namespace string) *storage.Config {
 }
 
 func (m *mockApolloClient) GetConfigAndInit(namespace string) *storage.Config {
+	m.syncedNamespaces = append(m.syncedNamespaces, namespace)
 	return nil
 }
 
@@ -283,6 +320,35 @@ func TestApolloSubscriber_HotReload(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, false, result["feature.enabled"])
 	assert.Equal(t, "added", result["feature.new"])
+
+	// AddChangeListener callers should see a structured diff of exactly
+	// what changed, built from agollo's own ChangeEvent.
+	var diff ConfigChange
+	sub.AddChangeListener(func(c ConfigChange) {
+		mu.Lock()
+		diff = c
+		mu.Unlock()
+	})
+
+	sub.OnChange(newChangeEvent("application.json", map[string]*storage.ConfigChange{
+		"feature.enabled": {
+			OldValue:   "true",
+			NewValue:   "false",
+			ChangeType: storage.MODIFIED,
+		},
+		"feature.new": {
+			NewValue:   "added",
+			ChangeType: storage.ADDED,
+		},
+	}))
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, "application.json", diff.Namespace)
+	assert.Equal(t, ChangeEntry{OldValue: "true", NewValue: "false"}, diff.Modified["feature.enabled"])
+	assert.Equal(t, ChangeEntry{NewValue: "added"}, diff.Added["feature.new"])
+	mu.Unlock()
 }
 
 // TestApolloSubscriber_MultipleListeners tests multiple listener registration
@@ -393,3 +459,367 @@ func TestApolloSubscriber_ConcurrentAccess(t *testing.T) {
 	wg.Wait()
 	// If we get here without deadlock or panic, the test passes
 }
+
+// TestApolloSubscriber_LoadValue_MixedNamespaceFormats exercises the
+// motivating multi-namespace scenario: one subscriber bound to
+// application.json, database.yaml and features.properties, with
+// Format: "json" set so the go-zero ConfigCenter can unmarshal the
+// rendered output. Each source namespace must still be parsed by its own
+// suffix, not reparsed as JSON just because the output format is JSON.
+func TestApolloSubscriber_LoadValue_MixedNamespaceFormats(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("name", "test-app", 0)
+	client.withNamespaceCache("database.yaml").Set(contentKey, "host: localhost\nport: 3306\n", 0)
+	client.withNamespaceCache("features.properties").Set("feature.enabled", "true", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName: "application.json",
+			Namespaces:    []string{"database.yaml", "features.properties"},
+			Format:        "json",
+		},
+		namespaces: []string{"application.json", "database.yaml", "features.properties"},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+
+	db, ok := result["database.yaml"].(map[string]interface{})
+	assert.True(t, ok, "database.yaml should parse as a nested YAML document, not fail JSON parsing")
+	assert.Equal(t, "localhost", db["host"])
+	assert.Equal(t, float64(3306), db["port"])
+
+	features, ok := result["features.properties"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "true", features["feature.enabled"])
+}
+
+// TestBuildApolloConfig_JoinsNamespaces verifies every namespace a
+// subscriber watches gets synced and long-poll-watched from startup, not
+// just the first one.
+func TestBuildApolloConfig_JoinsNamespaces(t *testing.T) {
+	conf := ApolloConf{
+		AppID:         "test-app",
+		MetaAddr:      "http://localhost:8080",
+		NamespaceName: "application.json",
+		Namespaces:    []string{"database.yaml", "features.properties"},
+	}
+
+	apolloConf := buildApolloConfig(conf)
+
+	assert.Equal(t, "application.json,database.yaml,features.properties", apolloConf.NamespaceName)
+}
+
+// TestApolloSubscriber_SyncAndUpdate tests that joining a namespace at
+// runtime both syncs it immediately and registers it with agollo's own
+// AppConfig, so it keeps being watched on later poll cycles rather than
+// only being fetched once.
+func TestApolloSubscriber_SyncAndUpdate(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("name", "test-app", 0)
+
+	appConfig := &config.AppConfig{NamespaceName: "application.json"}
+	sub := &apolloSubscriber{
+		client:     client,
+		conf:       ApolloConf{NamespaceName: "application.json"},
+		namespaces: []string{"application.json"},
+		appConfig:  appConfig,
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	// Seed the namespace being joined as if agollo had just synced it.
+	client.withNamespaceCache("features.properties").Set("feature.enabled", "true", 0)
+
+	err = sub.SyncAndUpdate("features.properties")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"features.properties"}, client.syncedNamespaces)
+	assert.Equal(t, "application.json,features.properties", appConfig.NamespaceName)
+	assert.Contains(t, sub.conf.Namespaces, "features.properties")
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+	assert.Contains(t, result, "features.properties")
+
+	// Already-watched namespaces are a no-op: no extra sync, no duplicate
+	// AppConfig entry.
+	err = sub.SyncAndUpdate("features.properties")
+	assert.NoError(t, err)
+	assert.Len(t, client.syncedNamespaces, 1)
+	assert.Equal(t, "application.json,features.properties", appConfig.NamespaceName)
+}
+
+// writeBackupFile writes an agollo-style backup file at
+// dir/appID/namespace.json, the layout loadFromBackup reads from.
+func writeBackupFile(t *testing.T, dir, appID, namespace string, doc map[string]interface{}) {
+	t.Helper()
+
+	nsDir := filepath.Join(dir, appID)
+	assert.NoError(t, os.MkdirAll(nsDir, 0o755))
+
+	raw, err := json.Marshal(doc)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(nsDir, namespace+".json"), raw, 0o644))
+}
+
+// TestApolloSubscriber_LoadFromBackup_Key tests that a Key-bound
+// subscriber still gets the bare value it expects from the backup
+// fallback, not the whole merged document.
+func TestApolloSubscriber_LoadFromBackup_Key(t *testing.T) {
+	dir := t.TempDir()
+	writeBackupFile(t, dir, "test-app", "application", map[string]interface{}{
+		"database.url":  "mysql://localhost:3306/test",
+		"database.user": "root",
+	})
+
+	sub := &apolloSubscriber{
+		client:     newMockApolloClient(),
+		namespaces: []string{"application"},
+		conf: ApolloConf{
+			NamespaceName: "application",
+			AppID:         "test-app",
+			BackupPath:    dir,
+			Key:           "database.url",
+		},
+	}
+
+	err := sub.loadFromBackup()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "mysql://localhost:3306/test", value)
+}
+
+// TestApolloSubscriber_LoadFromBackup_KeyPrefix tests that a
+// KeyPrefix-bound subscriber gets the projected subtree from the backup
+// fallback too, including the segment-boundary rule that keeps a sibling
+// namespace like myservice.http2 out of the myservice.http subtree.
+func TestApolloSubscriber_LoadFromBackup_KeyPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeBackupFile(t, dir, "test-app", "application", map[string]interface{}{
+		"myservice.http.port": "8080",
+		"myservice.http2.foo": "bar",
+		"other":               "value",
+	})
+
+	sub := &apolloSubscriber{
+		client:     newMockApolloClient(),
+		namespaces: []string{"application"},
+		conf: ApolloConf{
+			NamespaceName: "application",
+			AppID:         "test-app",
+			BackupPath:    dir,
+			KeyPrefix:     "myservice.http",
+		},
+	}
+
+	err := sub.loadFromBackup()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "8080", result["port"])
+	_, leaked := result["2"]
+	assert.False(t, leaked, "myservice.http2 must not be pulled into the myservice.http subtree")
+	assert.Len(t, result, 1, "only the myservice.http subtree should be present")
+}
+
+// TestApolloSubscriber_Decryptor_StructLiteral tests that a subscriber
+// built as a direct struct literal - the way every test in this package,
+// and any other same-package caller, builds one - still decrypts
+// correctly instead of panicking on the nil encryptedKey that only
+// NewApolloSubscriber would have compiled.
+func TestApolloSubscriber_Decryptor_StructLiteral(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("ENC(database.password)", "ciphertext", 0)
+	client.configCache.Set("database.user", "root", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName: "application.json",
+			Format:        "json",
+			Decryptor: func(namespace, key string, ciphertext []byte) ([]byte, error) {
+				assert.Equal(t, "ENC(database.password)", key)
+				return []byte("plaintext"), nil
+			},
+		},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, "plaintext", result["ENC(database.password)"])
+	assert.Equal(t, "root", result["database.user"])
+}
+
+// TestApolloSubscriber_Decryptor_CustomPattern tests that
+// EncryptedKeyPattern, not just the default ENC(...) pattern, is honored
+// when lazily compiling the encrypted-key regexp.
+func TestApolloSubscriber_Decryptor_CustomPattern(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("secret.token", "s3cr3t", 0)
+	client.configCache.Set("public.name", "test-app", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName:       "application.json",
+			Format:              "json",
+			EncryptedKeyPattern: `^secret\..*$`,
+			Decryptor: func(namespace, key string, ciphertext []byte) ([]byte, error) {
+				return []byte("decrypted"), nil
+			},
+		},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, "decrypted", result["secret.token"])
+	assert.Equal(t, "test-app", result["public.name"])
+}
+
+// TestApolloSubscriber_LoadValue_KeyPrefix_SegmentBoundary tests that
+// KeyPrefix only pulls in its own subtree - "myservice.http" must not
+// also match the unrelated sibling key "myservice.http2.foo".
+func TestApolloSubscriber_LoadValue_KeyPrefix_SegmentBoundary(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("myservice.http.port", "8080", 0)
+	client.configCache.Set("myservice.http2.foo", "bar", 0)
+	client.configCache.Set("myservice.http", "exact-match-node", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName: "application.json",
+			Format:        "json",
+			KeyPrefix:     "myservice.http",
+		},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	value, err := sub.Value()
+	assert.NoError(t, err)
+
+	var result map[string]interface{}
+	err = json.Unmarshal([]byte(value), &result)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "8080", result["port"])
+	assert.Len(t, result, 1, "myservice.http2.foo and the exact-match node must not leak into the subtree")
+}
+
+// TestApolloSubscriber_OnChange_KeyPrefix_SegmentBoundary tests that a
+// change to an unrelated sibling key doesn't fire listeners for a
+// KeyPrefix-bound subscriber.
+func TestApolloSubscriber_OnChange_KeyPrefix_SegmentBoundary(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("myservice.http.port", "8080", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName: "application.json",
+			Format:        "json",
+			KeyPrefix:     "myservice.http",
+		},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	fired := false
+	sub.AddChangeListener(func(c ConfigChange) {
+		fired = true
+	})
+
+	sub.OnChange(newChangeEvent("application.json", map[string]*storage.ConfigChange{
+		"myservice.http2.foo": {
+			NewValue:   "bar",
+			ChangeType: storage.ADDED,
+		},
+	}))
+	assert.False(t, fired, "a change to an unrelated sibling key must not notify listeners")
+
+	sub.OnChange(newChangeEvent("application.json", map[string]*storage.ConfigChange{
+		"myservice.http.port": {
+			OldValue:   "8080",
+			NewValue:   "9090",
+			ChangeType: storage.MODIFIED,
+		},
+	}))
+	assert.True(t, fired, "a change under the watched prefix must notify listeners")
+}
+
+// TestApolloSubscriber_OnNewestChange_KeyPrefix tests that OnNewestChange
+// respects KeyPrefix the same way OnChange does.
+func TestApolloSubscriber_OnNewestChange_KeyPrefix(t *testing.T) {
+	client := newMockApolloClient()
+	client.configCache.Set("myservice.http.port", "8080", 0)
+
+	sub := &apolloSubscriber{
+		client: client,
+		conf: ApolloConf{
+			NamespaceName: "application.json",
+			Format:        "json",
+			KeyPrefix:     "myservice.http",
+		},
+	}
+
+	err := sub.loadValue()
+	assert.NoError(t, err)
+
+	reloaded := false
+	sub.AddListener(func() {
+		reloaded = true
+	})
+
+	sub.OnNewestChange(&storage.FullChangeEvent{
+		Changes: map[string]interface{}{
+			"myservice.http2.foo": "bar",
+		},
+	})
+	assert.False(t, reloaded, "a change to an unrelated sibling key must not trigger a reload")
+
+	sub.OnNewestChange(&storage.FullChangeEvent{
+		Changes: map[string]interface{}{
+			"myservice.http.port": "9090",
+		},
+	})
+	assert.True(t, reloaded, "a change under the watched prefix must trigger a reload")
+}
@@ -0,0 +1,141 @@
+package apollo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// contentKey is the cache key agollo stores an entire non-properties
+// namespace document under (json/yaml/xml/txt namespaces are not split
+// into individual keys the way .properties ones are).
+const contentKey = "content"
+
+// Parser turns the raw bytes of a namespace document into a map suitable
+// for merging into the subscriber's output document.
+type Parser interface {
+	Parse(namespace string, raw []byte) (map[string]interface{}, error)
+}
+
+type propertiesParser struct{}
+
+func (propertiesParser) Parse(_ string, raw []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			continue
+		}
+
+		doc[strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+	}
+	return doc, nil
+}
+
+type jsonParser struct{}
+
+func (jsonParser) Parse(namespace string, raw []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("apollo: parse json namespace %s: %w", namespace, err)
+	}
+	return doc, nil
+}
+
+type yamlParser struct{}
+
+func (yamlParser) Parse(namespace string, raw []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("apollo: parse yaml namespace %s: %w", namespace, err)
+	}
+	return doc, nil
+}
+
+type xmlParser struct{}
+
+func (xmlParser) Parse(namespace string, raw []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if err := xml.Unmarshal(raw, (*xmlMap)(&doc)); err != nil {
+		return nil, fmt.Errorf("apollo: parse xml namespace %s: %w", namespace, err)
+	}
+	return doc, nil
+}
+
+type txtParser struct{}
+
+func (txtParser) Parse(_ string, raw []byte) (map[string]interface{}, error) {
+	return map[string]interface{}{contentKey: string(raw)}, nil
+}
+
+// xmlMap is the minimal xml.Unmarshaler glue needed to decode an arbitrary
+// XML document into a map[string]interface{} of its top-level elements.
+type xmlMap map[string]interface{}
+
+func (m *xmlMap) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type entry struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	}
+
+	*m = make(map[string]interface{})
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			var e entry
+			if err := d.DecodeElement(&e, &se); err != nil {
+				return err
+			}
+			(*m)[se.Name.Local] = e.Value
+		}
+	}
+	return nil
+}
+
+// defaultParsers maps a namespace's format suffix to the builtin Parser
+// that understands agollo's on-disk representation for it.
+var defaultParsers = map[string]Parser{
+	"properties": propertiesParser{},
+	"json":       jsonParser{},
+	"yaml":       yamlParser{},
+	"yml":        yamlParser{},
+	"xml":        xmlParser{},
+	"txt":        txtParser{},
+}
+
+// parserFor picks the Parser used to decode namespace's own stored
+// content, preferring an explicit override, then the namespace's file
+// suffix, then falling back to the configured Format. Format controls how
+// Value() renders its *output* document, which is usually unrelated to
+// how any one source namespace is encoded: a subscriber spanning
+// application.json, database.yaml and features.properties with
+// Format: "json" must still parse each namespace by its own suffix
+// instead of reparsing all of them as JSON.
+func parserFor(namespace, format string, override Parser) Parser {
+	if override != nil {
+		return override
+	}
+
+	if idx := strings.LastIndex(namespace, "."); idx >= 0 {
+		if p, ok := defaultParsers[namespace[idx+1:]]; ok {
+			return p
+		}
+	}
+
+	if p, ok := defaultParsers[format]; ok {
+		return p
+	}
+
+	return defaultParsers["properties"]
+}
@@ -0,0 +1,36 @@
+package apollo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParserFor_SuffixBeatsFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		format    string
+		want      Parser
+	}{
+		{"yaml namespace with json output format", "database.yaml", "json", yamlParser{}},
+		{"properties namespace with json output format", "features.properties", "json", propertiesParser{}},
+		{"json namespace with json output format", "application.json", "json", jsonParser{}},
+		{"xml namespace with properties output format", "legacy.xml", "properties", xmlParser{}},
+		{"no recognized suffix falls back to format", "application", "yaml", yamlParser{}},
+		{"no suffix and no format falls back to properties", "application", "", propertiesParser{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parserFor(tt.namespace, tt.format, nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParserFor_OverrideWinsRegardless(t *testing.T) {
+	override := txtParser{}
+	got := parserFor("database.yaml", "json", override)
+	assert.Equal(t, override, got)
+}